@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const checkpointStateTable = "public._datatransfer_state"
+
+// checkpointStore persists, per destination table, the last value seen in
+// CHECKPOINT_COLUMN so an interrupted run can resume instead of re-reading
+// rows it already copied.
+type checkpointStore interface {
+	load(ctx context.Context, destTable string) (value string, found bool, err error)
+	save(ctx context.Context, destTable, value string) error
+}
+
+// newCheckpointStore builds a file-backed store when stateFile is set, and
+// a _datatransfer_state table in the destination database otherwise.
+func newCheckpointStore(ctx context.Context, pool *pgxpool.Pool, stateFile string) (checkpointStore, error) {
+	if stateFile != "" {
+		return &fileCheckpointStore{path: stateFile}, nil
+	}
+
+	store := &dbCheckpointStore{pool: pool}
+	if err := store.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// dbCheckpointStore keeps checkpoints in a small table in the destination
+// database, keyed by destination table name.
+type dbCheckpointStore struct {
+	pool *pgxpool.Pool
+}
+
+func (s *dbCheckpointStore) ensureTable(ctx context.Context) error {
+	_, err := s.pool.Exec(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			dest_table text PRIMARY KEY,
+			checkpoint_value text NOT NULL,
+			updated_at timestamptz NOT NULL DEFAULT now()
+		)
+	`, checkpointStateTable))
+	return err
+}
+
+func (s *dbCheckpointStore) load(ctx context.Context, destTable string) (string, bool, error) {
+	var value string
+	err := s.pool.QueryRow(ctx,
+		fmt.Sprintf("SELECT checkpoint_value FROM %s WHERE dest_table = $1", checkpointStateTable),
+		destTable,
+	).Scan(&value)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+func (s *dbCheckpointStore) save(ctx context.Context, destTable, value string) error {
+	_, err := s.pool.Exec(ctx, fmt.Sprintf(`
+		INSERT INTO %s (dest_table, checkpoint_value, updated_at) VALUES ($1, $2, now())
+		ON CONFLICT (dest_table) DO UPDATE SET checkpoint_value = EXCLUDED.checkpoint_value, updated_at = now()
+	`, checkpointStateTable), destTable, value)
+	return err
+}
+
+// fileCheckpointStore keeps checkpoints in a small JSON file, for callers
+// whose source role lacks write access to the destination database.
+type fileCheckpointStore struct {
+	path string
+}
+
+func (s *fileCheckpointStore) readAll() (map[string]string, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := map[string]string{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (s *fileCheckpointStore) load(_ context.Context, destTable string) (string, bool, error) {
+	state, err := s.readAll()
+	if err != nil {
+		return "", false, err
+	}
+	value, found := state[destTable]
+	return value, found, nil
+}
+
+func (s *fileCheckpointStore) save(_ context.Context, destTable, value string) error {
+	state, err := s.readAll()
+	if err != nil {
+		return err
+	}
+	state[destTable] = value
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0o644)
+}
+
+// appendCheckpointFilter rewrites selectQuery to only return rows past the
+// last checkpoint, ordered by column so the next checkpoint is always the
+// row furthest along. lastSeen is embedded as an untyped SQL literal so
+// Postgres resolves it against column's actual type, whether that is a
+// timestamp or a bigint id.
+func appendCheckpointFilter(selectQuery, column, lastSeen string) string {
+	if lastSeen == "" {
+		return fmt.Sprintf("SELECT * FROM (%s) dt_checkpoint ORDER BY %s", selectQuery, column)
+	}
+	escaped := strings.ReplaceAll(lastSeen, "'", "''")
+	return fmt.Sprintf(
+		"SELECT * FROM (%s) dt_checkpoint WHERE %s > '%s' ORDER BY %s",
+		selectQuery, column, escaped, column,
+	)
+}
+
+// maxCheckpointValue returns the largest value of column idx in batch,
+// formatted as text for storage.
+func maxCheckpointValue(batch [][]any, idx int) string {
+	var max string
+	for i, row := range batch {
+		v := fmt.Sprintf("%v", row[idx])
+		if i == 0 || checkpointValueGreater(v, max) {
+			max = v
+		}
+	}
+	return max
+}
+
+// checkpointValueGreater reports whether a sorts after b. Values that parse
+// as numbers (the common case: a bigint or serial id column) are compared
+// numerically, since plain string comparison puts "9999" after "10000" and
+// would checkpoint a lower id than rows already copied. Anything else (e.g.
+// a timestamp's text representation) falls back to lexicographic order.
+func checkpointValueGreater(a, b string) bool {
+	af, aErr := strconv.ParseFloat(a, 64)
+	bf, bErr := strconv.ParseFloat(b, 64)
+	if aErr == nil && bErr == nil {
+		return af > bf
+	}
+	return a > b
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestMaxCheckpointValueNumeric(t *testing.T) {
+	batch := [][]any{
+		{"9998"},
+		{"9999"},
+		{"10000"},
+		{"10005"},
+	}
+	got := maxCheckpointValue(batch, 0)
+	if got != "10005" {
+		t.Fatalf("got %q, want %q", got, "10005")
+	}
+}
+
+func TestMaxCheckpointValueNonNumericFallsBackToString(t *testing.T) {
+	batch := [][]any{
+		{"2024-01-01T00:00:00Z"},
+		{"2024-06-01T00:00:00Z"},
+	}
+	got := maxCheckpointValue(batch, 0)
+	if got != "2024-06-01T00:00:00Z" {
+		t.Fatalf("got %q, want the later timestamp", got)
+	}
+}
+
+func TestAppendCheckpointFilter(t *testing.T) {
+	const q = "SELECT * FROM t"
+
+	noCheckpoint := appendCheckpointFilter(q, "id", "")
+	if noCheckpoint != "SELECT * FROM (SELECT * FROM t) dt_checkpoint ORDER BY id" {
+		t.Fatalf("got %q", noCheckpoint)
+	}
+
+	withCheckpoint := appendCheckpointFilter(q, "id", "10005")
+	want := "SELECT * FROM (SELECT * FROM t) dt_checkpoint WHERE id > '10005' ORDER BY id"
+	if withCheckpoint != want {
+		t.Fatalf("got %q, want %q", withCheckpoint, want)
+	}
+}
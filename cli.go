@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/spf13/cobra"
+)
+
+// cliFlags mirrors config one-for-one, before the raw strings (dest table,
+// force tables, csv delimiter, ...) have been parsed and validated into their
+// final shapes. Every flag except --read-timeout and --statement-timeout
+// takes its default from the matching environment variable (DATABASE_URL,
+// SELECT_QUERY, ...), so deployments configured through the environment keep
+// working unchanged.
+type cliFlags struct {
+	databaseURL         string
+	selectQuery         string
+	destTable           string
+	batchSize           int
+	progressEveryRows   int64
+	workers             int
+	splitColumn         string
+	verify              bool
+	verifyPKColumn      string
+	verifyBuckets       int
+	manifestFile        string
+	forceTables         string
+	checkpointColumn    string
+	checkpointStateFile string
+	sourceType          string
+	sinkType            string
+	sourceFile          string
+	sinkFile            string
+	csvDelimiter        string
+	csvHeader           bool
+	csvCompress         bool
+	fileSize            int64
+	logLevel            string
+	logFormat           string
+	readTimeout         time.Duration
+	statementTimeout    time.Duration
+}
+
+func envDefaultString(key, fallback string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envDefaultInt(key string, fallback int) int {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
+
+func envDefaultInt64(key string, fallback int64) int64 {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
+
+func envDefaultBool(key string, fallback bool) bool {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return fallback
+}
+
+// newRootCmd builds the datatransfer CLI. run is invoked with the parsed
+// flags once cobra has matched args; it is injected so this file owns flag
+// wiring and main.go owns the transfer itself.
+func newRootCmd(run func(flags cliFlags) error) *cobra.Command {
+	var flags cliFlags
+
+	cmd := &cobra.Command{
+		Use:           "datatransfer",
+		Short:         "Copy rows between Postgres tables, or to and from CSV files, with batching, sharding, and verification",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(flags)
+		},
+	}
+
+	fs := cmd.Flags()
+	fs.StringVar(&flags.databaseURL, "database-url", envDefaultString("DATABASE_URL", ""), "Destination connection string")
+	fs.StringVar(&flags.selectQuery, "select-query", envDefaultString("SELECT_QUERY", ""), "SELECT statement to read from the source")
+	fs.StringVar(&flags.destTable, "dest-table", envDefaultString("DEST_TABLE", ""), "Destination table, as table or schema.table")
+	fs.IntVar(&flags.batchSize, "batch-size", envDefaultInt("BATCH_SIZE", defaultBatchSize), "Rows per CopyFrom batch")
+	fs.Int64Var(&flags.progressEveryRows, "progress-every-rows", envDefaultInt64("PROGRESS_EVERY_ROWS", defaultProgressEveryRows), "Log a rate line every N rows")
+	fs.IntVar(&flags.workers, "workers", envDefaultInt("WORKERS", defaultWorkers), "Number of parallel workers sharding the SELECT")
+	fs.StringVar(&flags.splitColumn, "split-column", envDefaultString("SPLIT_COLUMN", ""), "Column to hash-shard the SELECT on, instead of OFFSET/LIMIT")
+	fs.BoolVar(&flags.verify, "verify", envDefaultBool("VERIFY", false), "Verify source and destination row hashes match after the transfer")
+	fs.StringVar(&flags.verifyPKColumn, "verify-pk-column", envDefaultString("VERIFY_PK_COLUMN", ""), "Column to ORDER BY when hashing rows for verification")
+	fs.IntVar(&flags.verifyBuckets, "verify-buckets", envDefaultInt("VERIFY_BUCKETS", defaultVerifyBuckets), "Number of buckets used to verify large tables")
+	fs.StringVar(&flags.manifestFile, "manifest-file", envDefaultString("MANIFEST_FILE", ""), "YAML or JSON manifest of tables to transfer, FK-ordered")
+	fs.StringVar(&flags.forceTables, "force-tables", envDefaultString("FORCE_TABLES", ""), "Comma-separated dest tables to copy with FK checks deferred")
+	fs.StringVar(&flags.checkpointColumn, "checkpoint-column", envDefaultString("CHECKPOINT_COLUMN", ""), "Monotonic column used to checkpoint and resume a transfer")
+	fs.StringVar(&flags.checkpointStateFile, "checkpoint-state-file", envDefaultString("CHECKPOINT_STATE_FILE", ""), "File to store checkpoints in, instead of the destination database")
+	fs.StringVar(&flags.sourceType, "source-type", envDefaultString("SOURCE_TYPE", "pg"), "Source kind: pg or csv")
+	fs.StringVar(&flags.sinkType, "sink-type", envDefaultString("SINK_TYPE", "pg"), "Sink kind: pg or csv")
+	fs.StringVar(&flags.sourceFile, "source-file", envDefaultString("SOURCE_FILE", ""), "CSV file to read from when --source-type=csv")
+	fs.StringVar(&flags.sinkFile, "sink-file", envDefaultString("SINK_FILE", ""), "CSV file to write to when --sink-type=csv")
+	fs.StringVar(&flags.csvDelimiter, "csv-delimiter", envDefaultString("CSV_DELIMITER", ","), "CSV field delimiter, a single character")
+	fs.BoolVar(&flags.csvHeader, "csv-header", envDefaultBool("CSV_HEADER", true), "Treat the first CSV line as a header row")
+	fs.BoolVar(&flags.csvCompress, "csv-compress", envDefaultBool("CSV_COMPRESS", false), "gzip-compress CSV output, or expect it on input")
+	fs.Int64Var(&flags.fileSize, "file-size", envDefaultInt64("FILE_SIZE", 0), "Rotate CSV sink output after this many bytes (0 disables rotation)")
+	fs.StringVar(&flags.logLevel, "log-level", envDefaultString("LOG_LEVEL", "info"), "Log level: debug, info, warn, or error")
+	fs.StringVar(&flags.logFormat, "log-format", envDefaultString("LOG_FORMAT", "console"), "Log format: json or console")
+	fs.DurationVar(&flags.readTimeout, "read-timeout", 0, "Abort a worker's source SELECT if it has not finished within this long, whether hung or still reading (0 disables)")
+	fs.DurationVar(&flags.statementTimeout, "statement-timeout", 0, "Postgres statement_timeout applied to every pool connection (0 disables)")
+
+	return cmd
+}
+
+// configFromFlags validates flags and parses its raw strings (dest table,
+// force tables, csv delimiter, ...) into a config.
+func configFromFlags(f cliFlags) (config, error) {
+	databaseURL := strings.TrimSpace(f.databaseURL)
+	if databaseURL == "" {
+		return config{}, fmt.Errorf("--database-url (or DATABASE_URL) is required")
+	}
+
+	manifestFile := strings.TrimSpace(f.manifestFile)
+
+	sourceType := strings.TrimSpace(f.sourceType)
+	if sourceType == "" {
+		sourceType = "pg"
+	}
+	if sourceType != "pg" && sourceType != "csv" {
+		return config{}, fmt.Errorf("invalid --source-type: %q (must be pg or csv)", sourceType)
+	}
+
+	sinkType := strings.TrimSpace(f.sinkType)
+	if sinkType == "" {
+		sinkType = "pg"
+	}
+	if sinkType != "pg" && sinkType != "csv" {
+		return config{}, fmt.Errorf("invalid --sink-type: %q (must be pg or csv)", sinkType)
+	}
+
+	var selectQuery, sourceFile string
+	var destTable pgx.Identifier
+	var sinkFile string
+	if manifestFile == "" {
+		if sourceType == "pg" {
+			selectQuery = strings.TrimSpace(f.selectQuery)
+			if selectQuery == "" {
+				return config{}, fmt.Errorf("--select-query (or SELECT_QUERY) is required")
+			}
+		} else {
+			sourceFile = strings.TrimSpace(f.sourceFile)
+			if sourceFile == "" {
+				return config{}, fmt.Errorf("--source-file (or SOURCE_FILE) is required when --source-type=csv")
+			}
+		}
+
+		if sinkType == "pg" {
+			destTableRaw := strings.TrimSpace(f.destTable)
+			if destTableRaw == "" {
+				return config{}, fmt.Errorf("--dest-table (or DEST_TABLE) is required (e.g. pm.snmp_metrics_interface)")
+			}
+			var err error
+			destTable, err = parsePgIdentifier(destTableRaw)
+			if err != nil {
+				return config{}, fmt.Errorf("invalid --dest-table: %w", err)
+			}
+		} else {
+			sinkFile = strings.TrimSpace(f.sinkFile)
+			if sinkFile == "" {
+				return config{}, fmt.Errorf("--sink-file (or SINK_FILE) is required when --sink-type=csv")
+			}
+		}
+	}
+
+	csvOpts := defaultCSVOptions()
+	if raw := strings.TrimSpace(f.csvDelimiter); raw != "" {
+		if len([]rune(raw)) != 1 {
+			return config{}, fmt.Errorf("invalid --csv-delimiter: %q (must be a single character)", raw)
+		}
+		csvOpts.delimiter = []rune(raw)[0]
+	}
+	csvOpts.header = f.csvHeader
+	csvOpts.compress = f.csvCompress
+
+	if f.fileSize < 0 {
+		return config{}, fmt.Errorf("invalid --file-size: %d", f.fileSize)
+	}
+	sinkFileSizeBytes := f.fileSize
+
+	if f.batchSize <= 0 {
+		return config{}, fmt.Errorf("invalid --batch-size: %d", f.batchSize)
+	}
+	if f.progressEveryRows <= 0 {
+		return config{}, fmt.Errorf("invalid --progress-every-rows: %d", f.progressEveryRows)
+	}
+	if f.workers <= 0 {
+		return config{}, fmt.Errorf("invalid --workers: %d", f.workers)
+	}
+	if f.verifyBuckets <= 0 {
+		return config{}, fmt.Errorf("invalid --verify-buckets: %d", f.verifyBuckets)
+	}
+
+	forceTables := map[string]bool{}
+	if raw := strings.TrimSpace(f.forceTables); raw != "" {
+		for _, part := range strings.Split(raw, ",") {
+			id, err := parsePgIdentifier(part)
+			if err != nil {
+				return config{}, fmt.Errorf("invalid --force-tables entry %q: %w", part, err)
+			}
+			forceTables[strings.Join(id, ".")] = true
+		}
+	}
+
+	// A forced table's rows land inside a transaction that can still roll
+	// back; a checkpoint saved against the shared pool would then have
+	// advanced past rows the destination never actually committed, and
+	// WHERE checkpoint_column > last_seen skips them forever on resume.
+	if strings.TrimSpace(f.checkpointColumn) != "" && len(forceTables) > 0 {
+		return config{}, fmt.Errorf("--checkpoint-column is not supported together with --force-tables")
+	}
+
+	// Every worker saves to the same single-row checkpoint key with no
+	// ordering guarantee across shards: a worker further along its shard can
+	// persist a higher checkpoint value while another is still copying
+	// lower-valued rows, and an interruption then permanently skips rows the
+	// slower worker never got to.
+	if strings.TrimSpace(f.checkpointColumn) != "" && f.workers > 1 {
+		return config{}, fmt.Errorf("--checkpoint-column is not supported together with --workers > 1")
+	}
+
+	return config{
+		databaseURL:         databaseURL,
+		selectQuery:         selectQuery,
+		destTable:           destTable,
+		batchSize:           f.batchSize,
+		progressEveryRows:   f.progressEveryRows,
+		workers:             f.workers,
+		splitColumn:         strings.TrimSpace(f.splitColumn),
+		verify:              f.verify,
+		verifyPKColumn:      strings.TrimSpace(f.verifyPKColumn),
+		verifyBuckets:       f.verifyBuckets,
+		manifestFile:        manifestFile,
+		forceTables:         forceTables,
+		checkpointColumn:    strings.TrimSpace(f.checkpointColumn),
+		checkpointStateFile: strings.TrimSpace(f.checkpointStateFile),
+		sourceType:          sourceType,
+		sinkType:            sinkType,
+		sourceFile:          sourceFile,
+		sinkFile:            sinkFile,
+		csvOpts:             csvOpts,
+		sinkFileSizeBytes:   sinkFileSizeBytes,
+		logLevel:            strings.TrimSpace(f.logLevel),
+		logFormat:           strings.TrimSpace(f.logFormat),
+		readTimeout:         f.readTimeout,
+		statementTimeout:    f.statementTimeout,
+	}, nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// csvOptions configures delimiter, header, and compression for both the CSV
+// source and the CSV sink.
+type csvOptions struct {
+	delimiter rune
+	header    bool
+	compress  bool
+}
+
+func defaultCSVOptions() csvOptions {
+	return csvOptions{delimiter: ',', header: true}
+}
+
+// csvRowSource reads rows from a CSV file. Unless opts.header is false, the
+// first line is treated as the column names rather than a data row.
+type csvRowSource struct {
+	file    *os.File
+	gzr     *gzip.Reader
+	reader  *csv.Reader
+	cols    []string
+	pending []string // first record, peeked in newCSVSource to populate cols when there's no header
+}
+
+func newCSVSource(path string, opts csvOptions) (rowSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CSV source %s: %w", path, err)
+	}
+
+	var r io.Reader = f
+	var gzr *gzip.Reader
+	if opts.compress || strings.HasSuffix(path, ".gz") {
+		gzr, err = gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to open gzip reader for %s: %w", path, err)
+		}
+		r = gzr
+	}
+
+	reader := csv.NewReader(r)
+	reader.Comma = opts.delimiter
+
+	src := &csvRowSource{file: f, gzr: gzr, reader: reader}
+
+	if opts.header {
+		header, err := reader.Read()
+		if err != nil {
+			src.close()
+			return nil, fmt.Errorf("failed to read CSV header from %s: %w", path, err)
+		}
+		src.cols = header
+	} else {
+		// columns() is called once right after buildSource returns, before
+		// any row is read, so cols must be known now rather than lazily on
+		// the first next(): peek the first record to learn the column
+		// count and carry it over so next() doesn't lose it.
+		first, err := reader.Read()
+		if err != nil && !errors.Is(err, io.EOF) {
+			src.close()
+			return nil, fmt.Errorf("failed to read CSV from %s: %w", path, err)
+		}
+		if err == nil {
+			src.cols = make([]string, len(first))
+			for i := range first {
+				src.cols[i] = fmt.Sprintf("col%d", i+1)
+			}
+			src.pending = first
+		}
+	}
+
+	return src, nil
+}
+
+func (s *csvRowSource) columns() []string { return s.cols }
+
+func (s *csvRowSource) next(_ context.Context) ([]any, error) {
+	record := s.pending
+	if record != nil {
+		s.pending = nil
+	} else {
+		var err error
+		record, err = s.reader.Read()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	row := make([]any, len(record))
+	for i, v := range record {
+		row[i] = v
+	}
+	return row, nil
+}
+
+func (s *csvRowSource) close() {
+	if s.gzr != nil {
+		s.gzr.Close()
+	}
+	s.file.Close()
+}
+
+// csvRowSink writes rows to a CSV file, rotating to a new numbered part once
+// the current file reaches fileSizeBytes (0 disables rotation, matching a
+// dump into a single file).
+type csvRowSink struct {
+	basePath      string
+	opts          csvOptions
+	fileSizeBytes int64
+
+	file   *os.File
+	gzw    *gzip.Writer
+	writer *csv.Writer
+	part   int
+}
+
+func newCSVSink(path string, opts csvOptions, fileSizeBytes int64) (rowSink, error) {
+	return &csvRowSink{basePath: path, opts: opts, fileSizeBytes: fileSizeBytes}, nil
+}
+
+func (s *csvRowSink) write(ctx context.Context, columns []string, batch [][]any) error {
+	if s.writer == nil {
+		if err := s.openPart(columns); err != nil {
+			return err
+		}
+	}
+
+	for _, row := range batch {
+		record := make([]string, len(row))
+		for i, v := range row {
+			record[i] = fmt.Sprintf("%v", v)
+		}
+		if err := s.writer.Write(record); err != nil {
+			return err
+		}
+	}
+	s.writer.Flush()
+	if err := s.writer.Error(); err != nil {
+		return err
+	}
+
+	if s.fileSizeBytes <= 0 {
+		return nil
+	}
+
+	if s.gzw != nil {
+		// gzip.Writer buffers internally and doesn't reach the underlying
+		// file until flushed, so without this Stat below would see close to
+		// nothing written regardless of how much CSV data has gone through,
+		// making rotation a no-op under compression.
+		if err := s.gzw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() >= s.fileSizeBytes {
+		return s.closePart()
+	}
+	return nil
+}
+
+func (s *csvRowSink) openPart(columns []string) error {
+	s.part++
+	path := s.partPath()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create CSV file %s: %w", path, err)
+	}
+	s.file = f
+
+	var w io.Writer = f
+	if s.opts.compress {
+		s.gzw = gzip.NewWriter(f)
+		w = s.gzw
+	}
+
+	s.writer = csv.NewWriter(w)
+	s.writer.Comma = s.opts.delimiter
+
+	if s.opts.header {
+		if err := s.writer.Write(columns); err != nil {
+			return err
+		}
+		s.writer.Flush()
+	}
+	return nil
+}
+
+// partPath returns basePath unchanged when rotation is disabled, and
+// basePath with a zero-padded part number spliced in before the extension
+// otherwise (out.csv -> out.0001.csv).
+func (s *csvRowSink) partPath() string {
+	if s.fileSizeBytes <= 0 {
+		return s.basePath
+	}
+	ext := filepath.Ext(s.basePath)
+	base := strings.TrimSuffix(s.basePath, ext)
+	return fmt.Sprintf("%s.%04d%s", base, s.part, ext)
+}
+
+func (s *csvRowSink) closePart() error {
+	s.writer.Flush()
+	if err := s.writer.Error(); err != nil {
+		return err
+	}
+	if s.gzw != nil {
+		if err := s.gzw.Close(); err != nil {
+			return err
+		}
+		s.gzw = nil
+	}
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	s.writer = nil
+	s.file = nil
+	return nil
+}
+
+func (s *csvRowSink) close() error {
+	if s.writer == nil {
+		return nil
+	}
+	return s.closePart()
+}
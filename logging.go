@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// setupLogging installs the process-wide structured logger. format is
+// "json" for machine-readable output or "console" for human-readable text;
+// level is one of debug, info, warn, or error.
+func setupLogging(level, format string) error {
+	var lvl slog.Level
+	switch strings.ToLower(level) {
+	case "debug":
+		lvl = slog.LevelDebug
+	case "", "info":
+		lvl = slog.LevelInfo
+	case "warn", "warning":
+		lvl = slog.LevelWarn
+	case "error":
+		lvl = slog.LevelError
+	default:
+		return fmt.Errorf("invalid log level: %q (must be debug, info, warn, or error)", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	case "", "console":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("invalid log format: %q (must be json or console)", format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// logQuery records one SQL statement at debug level, with enough structured
+// fields (duration, rows affected, destination table) to tell which SELECT,
+// CopyFrom, or verification query was slow.
+func logQuery(op, destTable string, start time.Time, rows int64, err error) {
+	attrs := []slog.Attr{
+		slog.String("op", op),
+		slog.Duration("duration", time.Since(start)),
+		slog.Int64("rows", rows),
+	}
+	if destTable != "" {
+		attrs = append(attrs, slog.String("dest_table", destTable))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	slog.LogAttrs(context.Background(), slog.LevelDebug, "sql", attrs...)
+}
+
+// fatal logs msg at error level and exits the process with status 1.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -2,11 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"math"
 	"os"
-	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -17,96 +21,129 @@ import (
 const (
 	defaultBatchSize         = 5000
 	defaultProgressEveryRows = int64(1_000_000)
+	defaultWorkers           = 1
+	defaultVerifyBuckets     = 100
 )
 
 type config struct {
-	databaseURL       string
-	selectQuery       string
-	destTable         pgx.Identifier
-	batchSize         int
-	progressEveryRows int64
+	databaseURL         string
+	selectQuery         string
+	destTable           pgx.Identifier
+	batchSize           int
+	progressEveryRows   int64
+	workers             int
+	splitColumn         string
+	verify              bool
+	verifyPKColumn      string
+	verifyBuckets       int
+	manifestFile        string
+	forceTables         map[string]bool
+	checkpointColumn    string
+	checkpointStateFile string
+	checkpointStore     checkpointStore
+	sourceType          string
+	sinkType            string
+	sourceFile          string
+	sinkFile            string
+	csvOpts             csvOptions
+	sinkFileSizeBytes   int64
+	logLevel            string
+	logFormat           string
+	readTimeout         time.Duration
+	statementTimeout    time.Duration
+}
+
+// querier is satisfied by *pgxpool.Pool and by pgx.Tx, so the transfer and
+// verification helpers can run either against the shared pool or inside a
+// single transaction (used to defer FK checks for --force tables).
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
 }
 
 func main() {
 	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found, using environment variables")
+		fmt.Fprintln(os.Stderr, "No .env file found, using environment variables")
+	}
+
+	cmd := newRootCmd(run)
+	if err := cmd.Execute(); err != nil {
+		fatal(err.Error())
 	}
+}
 
-	cfg, err := loadConfig()
+// run builds a config from flags, opens the destination pool, and carries
+// out whatever cfg describes: a manifest transfer, a single transferData,
+// and an optional verify pass. It is the body cli.go's cobra command runs.
+func run(f cliFlags) error {
+	cfg, err := configFromFlags(f)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	if err := setupLogging(cfg.logLevel, cfg.logFormat); err != nil {
+		return err
 	}
 
 	ctx := context.Background()
 
 	poolConfig, err := pgxpool.ParseConfig(cfg.databaseURL)
 	if err != nil {
-		log.Fatalf("Failed to parse connection string: %v", err)
+		return fmt.Errorf("failed to parse connection string: %w", err)
 	}
 
-	poolConfig.MaxConns = 10
+	poolConfig.MaxConns = int32(math.Max(10, float64(cfg.workers+2)))
 	poolConfig.MinConns = 2
+	if cfg.statementTimeout > 0 {
+		poolConfig.ConnConfig.RuntimeParams["statement_timeout"] = fmt.Sprintf("%d", cfg.statementTimeout.Milliseconds())
+	}
 
 	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		return fmt.Errorf("failed to connect to database: %w", err)
 	}
 	defer pool.Close()
 
-	log.Println("Connected to database")
+	slog.Info("connected to database")
 
-	if err := transferData(ctx, pool, cfg); err != nil {
-		log.Fatalf("Transfer failed: %v", err)
+	if cfg.checkpointColumn != "" {
+		store, err := newCheckpointStore(ctx, pool, cfg.checkpointStateFile)
+		if err != nil {
+			return fmt.Errorf("failed to initialize checkpoint store: %w", err)
+		}
+		cfg.checkpointStore = store
 	}
 
-	log.Println("Data transfer completed successfully")
-}
-
-func loadConfig() (config, error) {
-	databaseURL := strings.TrimSpace(os.Getenv("DATABASE_URL"))
-	if databaseURL == "" {
-		return config{}, fmt.Errorf("DATABASE_URL environment variable is required")
+	if cfg.manifestFile != "" {
+		m, err := loadManifest(cfg.manifestFile)
+		if err != nil {
+			return fmt.Errorf("failed to load manifest: %w", err)
+		}
+		if err := transferManifest(ctx, pool, cfg, m); err != nil {
+			return fmt.Errorf("manifest transfer failed: %w", err)
+		}
+		slog.Info("manifest transfer completed successfully")
+		return nil
 	}
 
-	selectQuery := strings.TrimSpace(os.Getenv("SELECT_QUERY"))
-	if selectQuery == "" {
-		return config{}, fmt.Errorf("SELECT_QUERY environment variable is required")
+	if err := transferData(ctx, pool, cfg); err != nil {
+		return fmt.Errorf("transfer failed: %w", err)
 	}
 
-	destTableRaw := strings.TrimSpace(os.Getenv("DEST_TABLE"))
-	if destTableRaw == "" {
-		return config{}, fmt.Errorf("DEST_TABLE environment variable is required (e.g. pm.snmp_metrics_interface)")
-	}
-	destTable, err := parsePgIdentifier(destTableRaw)
-	if err != nil {
-		return config{}, fmt.Errorf("invalid DEST_TABLE: %w", err)
-	}
+	slog.Info("data transfer completed successfully")
 
-	batchSize := defaultBatchSize
-	if raw := strings.TrimSpace(os.Getenv("BATCH_SIZE")); raw != "" {
-		v, err := strconv.Atoi(raw)
-		if err != nil || v <= 0 {
-			return config{}, fmt.Errorf("invalid BATCH_SIZE: %q", raw)
+	if cfg.verify {
+		result, err := verifyTransfer(ctx, pool, cfg)
+		if err != nil {
+			return fmt.Errorf("verification failed: %w", err)
 		}
-		batchSize = v
-	}
-
-	progressEveryRows := defaultProgressEveryRows
-	if raw := strings.TrimSpace(os.Getenv("PROGRESS_EVERY_ROWS")); raw != "" {
-		v, err := strconv.ParseInt(raw, 10, 64)
-		if err != nil || v <= 0 {
-			return config{}, fmt.Errorf("invalid PROGRESS_EVERY_ROWS: %q", raw)
+		if err := logReport(result); err != nil {
+			return err
 		}
-		progressEveryRows = v
 	}
 
-	return config{
-		databaseURL:       databaseURL,
-		selectQuery:       selectQuery,
-		destTable:         destTable,
-		batchSize:         batchSize,
-		progressEveryRows: progressEveryRows,
-	}, nil
+	return nil
 }
 
 func parsePgIdentifier(raw string) (pgx.Identifier, error) {
@@ -133,64 +170,251 @@ func parsePgIdentifier(raw string) (pgx.Identifier, error) {
 	}
 }
 
-func transferData(ctx context.Context, pool *pgxpool.Pool, cfg config) error {
-	startTime := time.Now()
+func destTableName(t pgx.Identifier) string {
+	return strings.Join(t, ".")
+}
 
-	log.Printf("Destination table: %s", strings.Join(cfg.destTable, "."))
-	log.Printf("Select query: %s", cfg.selectQuery)
-	log.Printf("Batch size: %d", cfg.batchSize)
+// checkpointKey identifies a transfer for checkpoint storage: the
+// destination table for a pg sink, or the output file for a csv sink.
+func checkpointKey(cfg config) string {
+	if cfg.sinkType == "csv" {
+		return cfg.sinkFile
+	}
+	return destTableName(cfg.destTable)
+}
 
-	rows, columns, err := queryRows(ctx, pool, cfg.selectQuery)
-	if err != nil {
-		return fmt.Errorf("failed to execute select query: %w", err)
+// transferData copies the rows of the configured source (cfg.selectQuery or
+// cfg.sourceFile) into the configured sink (cfg.destTable or cfg.sinkFile).
+// When cfg.workers is greater than 1 and the source is pg, the source read is
+// sharded across that many goroutines, each with its own connection and its
+// own insert loop; a csv source is always read by a single worker.
+func transferData(ctx context.Context, pool querier, cfg config) error {
+	startTime := time.Now()
+
+	if cfg.checkpointColumn != "" {
+		lastSeen, found, err := cfg.checkpointStore.load(ctx, checkpointKey(cfg))
+		if err != nil {
+			return fmt.Errorf("failed to load checkpoint: %w", err)
+		}
+		if found {
+			slog.Info("resuming from checkpoint", "checkpoint_column", cfg.checkpointColumn, "last_seen", lastSeen)
+		}
+		cfg.selectQuery = appendCheckpointFilter(cfg.selectQuery, cfg.checkpointColumn, lastSeen)
 	}
-	defer rows.Close()
 
-	log.Printf("Detected columns (%d): %s", len(columns), strings.Join(columns, ", "))
+	slog.Info("starting transfer",
+		"source_type", cfg.sourceType, "sink_type", cfg.sinkType,
+		"dest_table", destTableName(cfg.destTable), "batch_size", cfg.batchSize, "workers", cfg.workers,
+	)
+
+	var shards []string
+	if cfg.sourceType == "pg" {
+		slog.Debug("select query", "sql", cfg.selectQuery)
+		var err error
+		shards, err = planShards(ctx, pool, cfg)
+		if err != nil {
+			return fmt.Errorf("failed to plan shards: %w", err)
+		}
+	} else {
+		if cfg.workers > 1 {
+			slog.Warn("workers is ignored for a csv source; reading sequentially", "workers", cfg.workers, "source_file", cfg.sourceFile)
+		}
+		shards = []string{""}
+	}
 
-	batch := make([][]any, 0, cfg.batchSize)
 	var processedCount int64
 
-	for rows.Next() {
-		values, err := rows.Values()
+	var wg sync.WaitGroup
+	errCh := make(chan error, len(shards))
+
+	for workerID, shardQuery := range shards {
+		wg.Add(1)
+		go func(workerID int, shardQuery string) {
+			defer wg.Done()
+
+			rows, err := runWorker(ctx, pool, cfg, workerID, shardQuery, &processedCount, startTime)
+			if err != nil {
+				errCh <- fmt.Errorf("worker %d: %w", workerID, err)
+				return
+			}
+			elapsed := time.Since(startTime)
+			slog.Info("worker complete", "worker_id", workerID, "rows", rows, "rows_per_sec", float64(rows)/elapsed.Seconds())
+		}(workerID, shardQuery)
+	}
+
+	wg.Wait()
+	close(errCh)
+
+	for err := range errCh {
 		if err != nil {
 			return err
 		}
+	}
 
-		batch = append(batch, values)
-		if len(batch) < cfg.batchSize {
-			continue
+	slog.Info("transfer complete", "rows", atomic.LoadInt64(&processedCount), "duration", time.Since(startTime))
+	return nil
+}
+
+// planShards builds one SELECT per worker whose result sets are disjoint
+// and together cover cfg.selectQuery. With a single worker it returns the
+// query unchanged.
+func planShards(ctx context.Context, pool querier, cfg config) ([]string, error) {
+	if cfg.workers <= 1 {
+		return []string{cfg.selectQuery}, nil
+	}
+
+	if cfg.splitColumn != "" {
+		shards := make([]string, cfg.workers)
+		for workerID := range shards {
+			shards[workerID] = fmt.Sprintf(
+				"SELECT * FROM (%s) dt_shard WHERE abs(hashtext(dt_shard.%s::text)) %% %d = %d",
+				cfg.selectQuery, cfg.splitColumn, cfg.workers, workerID,
+			)
 		}
+		return shards, nil
+	}
+
+	var total int64
+	countQuery := fmt.Sprintf("SELECT count(*) FROM (%s) dt_count", cfg.selectQuery)
+	start := time.Now()
+	err := pool.QueryRow(ctx, countQuery).Scan(&total)
+	logQuery("count_rows", "", start, total, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count rows for sharding (set --split-column to avoid this): %w", err)
+	}
+
+	chunk := int64(math.Ceil(float64(total) / float64(cfg.workers)))
+	if chunk <= 0 {
+		return []string{cfg.selectQuery}, nil
+	}
+
+	shards := make([]string, cfg.workers)
+	for workerID := range shards {
+		shards[workerID] = fmt.Sprintf(
+			"SELECT * FROM (%s) dt_shard OFFSET %d LIMIT %d",
+			cfg.selectQuery, int64(workerID)*chunk, chunk,
+		)
+	}
+	return shards, nil
+}
+
+// runWorker streams shardQuery through a bounded channel into the sink so
+// a slow destination back-pressures the SELECT instead of growing batch
+// slices without bound. processedCount is shared across all workers so
+// PROGRESS_EVERY_ROWS fires on the global total.
+func runWorker(ctx context.Context, pool querier, cfg config, workerID int, shardQuery string, processedCount *int64, startTime time.Time) (rows int64, err error) {
+	source, err := buildSource(ctx, pool, cfg, shardQuery, cfg.readTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open source: %w", err)
+	}
+	defer source.close()
 
-		if err := insertBatch(ctx, pool, cfg.destTable, columns, batch); err != nil {
-			log.Printf("failed to insert batch: %s", err.Error())
+	sink, err := buildSink(pool, cfg, workerID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open sink: %w", err)
+	}
+	defer func() {
+		// sink.close() can fail to flush a CSV sink's final part (e.g.
+		// ENOSPC); don't let that be swallowed behind a successful-looking
+		// return.
+		if cerr := sink.close(); cerr != nil && err == nil {
+			err = fmt.Errorf("failed to close sink: %w", cerr)
 		}
-		processedCount += int64(len(batch))
-		batch = batch[:0]
+	}()
 
-		if processedCount%cfg.progressEveryRows == 0 {
-			elapsed := time.Since(startTime)
-			rate := float64(processedCount) / elapsed.Seconds()
-			log.Printf("Processed: %d rows - Rate: %.0f rows/sec", processedCount, rate)
+	columns := source.columns()
+
+	checkpointColIdx := -1
+	if cfg.checkpointColumn != "" {
+		for i, column := range columns {
+			if column == cfg.checkpointColumn {
+				checkpointColIdx = i
+				break
+			}
+		}
+		if checkpointColIdx == -1 {
+			return 0, fmt.Errorf("checkpoint column %q not present in select query", cfg.checkpointColumn)
 		}
 	}
 
-	if err := rows.Err(); err != nil {
-		return err
+	batches := make(chan [][]any, 2)
+	insertErrCh := make(chan error, 1)
+
+	go func() {
+		// Once a write fails we must keep draining batches (discarding them)
+		// rather than returning: the producer below keeps sending on batches
+		// until it has read the whole source, and with nobody left to
+		// receive it would block forever on the first full buffer instead
+		// of observing insertErrCh and stopping.
+		var firstErr error
+		for batch := range batches {
+			if firstErr != nil {
+				continue
+			}
+			if err := sink.write(ctx, columns, batch); err != nil {
+				firstErr = fmt.Errorf("failed to write batch: %w", err)
+				continue
+			}
+			reportProgress(processedCount, int64(len(batch)), cfg.progressEveryRows, startTime)
+
+			if checkpointColIdx >= 0 {
+				if err := cfg.checkpointStore.save(ctx, checkpointKey(cfg), maxCheckpointValue(batch, checkpointColIdx)); err != nil {
+					firstErr = fmt.Errorf("failed to save checkpoint: %w", err)
+				}
+			}
+		}
+		insertErrCh <- firstErr
+	}()
+
+	var workerRows int64
+	batch := make([][]any, 0, cfg.batchSize)
+
+	for {
+		values, err := source.next(ctx)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			close(batches)
+			<-insertErrCh
+			return workerRows, err
+		}
+
+		batch = append(batch, values)
+		workerRows++
+		if len(batch) < cfg.batchSize {
+			continue
+		}
+
+		batches <- batch
+		batch = make([][]any, 0, cfg.batchSize)
 	}
 
 	if len(batch) > 0 {
-		if err := insertBatch(ctx, pool, cfg.destTable, columns, batch); err != nil {
-			log.Printf("failed to insert final batch: %s", err.Error())
-		}
-		processedCount += int64(len(batch))
+		batches <- batch
 	}
+	close(batches)
 
-	log.Printf("Transfer complete. Total: %d rows in %v", processedCount, time.Since(startTime))
-	return nil
+	if err := <-insertErrCh; err != nil {
+		return workerRows, err
+	}
+	return workerRows, nil
+}
+
+// reportProgress atomically advances the shared row counter and logs a rate
+// line the first time it crosses a PROGRESS_EVERY_ROWS boundary.
+func reportProgress(processedCount *int64, delta, every int64, startTime time.Time) {
+	after := atomic.AddInt64(processedCount, delta)
+	before := after - delta
+	if before/every == after/every {
+		return
+	}
+	elapsed := time.Since(startTime)
+	rate := float64(after) / elapsed.Seconds()
+	slog.Info("progress", "rows", after, "rows_per_sec", rate)
 }
 
-func queryRows(ctx context.Context, pool *pgxpool.Pool, selectQuery string) (pgx.Rows, []string, error) {
+func queryRows(ctx context.Context, pool querier, selectQuery string) (pgx.Rows, []string, error) {
 	rows, err := pool.Query(ctx, selectQuery)
 	if err != nil {
 		return nil, nil, err
@@ -206,17 +430,19 @@ func queryRows(ctx context.Context, pool *pgxpool.Pool, selectQuery string) (pgx
 	return rows, columns, nil
 }
 
-func insertBatch(ctx context.Context, pool *pgxpool.Pool, destTable pgx.Identifier, columns []string, rows [][]any) error {
+func insertBatch(ctx context.Context, pool querier, destTable pgx.Identifier, columns []string, rows [][]any) error {
 	if len(rows) == 0 {
 		return nil
 	}
 
+	start := time.Now()
 	copyCount, err := pool.CopyFrom(
 		ctx,
 		destTable,
 		columns,
 		pgx.CopyFromRows(rows),
 	)
+	logQuery("copy_from", destTableName(destTable), start, copyCount, err)
 	if err != nil {
 		return err
 	}
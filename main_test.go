@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestPlanShardsSingleWorker(t *testing.T) {
+	cfg := config{selectQuery: "SELECT * FROM t", workers: 1}
+	shards, err := planShards(nil, nil, cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(shards) != 1 || shards[0] != cfg.selectQuery {
+		t.Fatalf("got %v, want the query unchanged", shards)
+	}
+}
+
+func TestPlanShardsSplitColumn(t *testing.T) {
+	cfg := config{selectQuery: "SELECT * FROM t", workers: 3, splitColumn: "id"}
+	shards, err := planShards(nil, nil, cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(shards) != 3 {
+		t.Fatalf("got %d shards, want 3", len(shards))
+	}
+	seen := map[string]bool{}
+	for _, s := range shards {
+		if seen[s] {
+			t.Fatalf("duplicate shard query: %s", s)
+		}
+		seen[s] = true
+	}
+}
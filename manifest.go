@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"gopkg.in/yaml.v3"
+)
+
+// tableSpec is one entry of a transfer manifest: a SELECT to run against the
+// source, the destination table it feeds, and optional overrides.
+type tableSpec struct {
+	Select    string `yaml:"select" json:"select"`
+	DestTable string `yaml:"dest_table" json:"dest_table"`
+	BatchSize int    `yaml:"batch_size,omitempty" json:"batch_size,omitempty"`
+	Where     string `yaml:"where,omitempty" json:"where,omitempty"`
+}
+
+// manifest lists every table a single run should transfer.
+type manifest struct {
+	Tables []tableSpec `yaml:"tables" json:"tables"`
+}
+
+// loadManifest reads a YAML or JSON manifest, chosen by file extension
+// (.yaml/.yml vs everything else, which is parsed as JSON).
+func loadManifest(path string) (*manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var m manifest
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &m)
+	default:
+		err = json.Unmarshal(data, &m)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if len(m.Tables) == 0 {
+		return nil, fmt.Errorf("manifest declares no tables")
+	}
+
+	return &m, nil
+}
+
+// transferManifest copies every table in m in an order derived from the
+// destination's foreign keys (parents before children), so child rows never
+// land before the parent row they reference. Tables listed in
+// cfg.forceTables are exempt from that ordering: their FK checks are
+// deferred to the end of a transaction instead.
+func transferManifest(ctx context.Context, pool *pgxpool.Pool, cfg config, m *manifest) error {
+	order, err := orderTables(ctx, pool, m.Tables, cfg.forceTables)
+	if err != nil {
+		return err
+	}
+
+	for _, spec := range order {
+		destTable, err := parsePgIdentifier(spec.DestTable)
+		if err != nil {
+			return fmt.Errorf("invalid dest_table %q: %w", spec.DestTable, err)
+		}
+
+		tableCfg := cfg
+		tableCfg.destTable = destTable
+		tableCfg.selectQuery = spec.Select
+		if spec.Where != "" {
+			tableCfg.selectQuery = fmt.Sprintf("SELECT * FROM (%s) dt_manifest WHERE %s", spec.Select, spec.Where)
+		}
+		if spec.BatchSize > 0 {
+			tableCfg.batchSize = spec.BatchSize
+		}
+
+		slog.Info("manifest: transferring table", "dest_table", spec.DestTable)
+
+		if cfg.forceTables[strings.Join(destTable, ".")] {
+			if err := transferForced(ctx, pool, tableCfg); err != nil {
+				return fmt.Errorf("table %s: %w", spec.DestTable, err)
+			}
+			continue
+		}
+
+		if err := transferData(ctx, pool, tableCfg); err != nil {
+			return fmt.Errorf("table %s: %w", spec.DestTable, err)
+		}
+	}
+
+	return nil
+}
+
+// transferForced runs a single table's transfer inside a transaction with
+// SET CONSTRAINTS ALL DEFERRED. Only foreign keys declared DEFERRABLE are
+// deferred, and the deferred checks run when this transaction commits,
+// before any later table of the manifest is copied: the commit fails if the
+// parent rows the table references are not already in the destination.
+func transferForced(ctx context.Context, pool *pgxpool.Pool, cfg config) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck // no-op once committed
+
+	if _, err := tx.Exec(ctx, "SET CONSTRAINTS ALL DEFERRED"); err != nil {
+		return fmt.Errorf("failed to defer constraints: %w", err)
+	}
+
+	// A forced table's transferData shares this single tx (and so the single
+	// underlying *pgx.Conn) across every worker; pgx.Conn isn't safe for
+	// concurrent use, so sharding across goroutines here would corrupt the
+	// connection rather than speed anything up.
+	if cfg.workers > 1 {
+		slog.Warn("workers is ignored for a forced table; reading through a single transaction", "workers", cfg.workers, "dest_table", destTableName(cfg.destTable))
+		cfg.workers = 1
+	}
+
+	if err := transferData(ctx, tx, cfg); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// orderTables topologically sorts tables so that, for every foreign key the
+// destination declares between two tables in the manifest, the parent comes
+// first. Tables in forced are exempt from incoming-FK ordering, which also
+// breaks any cycle that only involves them. Dependencies on tables outside
+// the manifest are ignored, since the destination is the only thing
+// introspected here: a read-only source role never needs catalog access.
+func orderTables(ctx context.Context, pool *pgxpool.Pool, tables []tableSpec, forced map[string]bool) ([]tableSpec, error) {
+	byName := make(map[string]tableSpec, len(tables))
+	names := make([]string, 0, len(tables))
+	for _, t := range tables {
+		id, err := parsePgIdentifier(t.DestTable)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dest_table %q: %w", t.DestTable, err)
+		}
+		name := strings.Join(id, ".")
+		byName[name] = t
+		names = append(names, name)
+	}
+
+	edges, err := fkEdges(ctx, pool, names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to introspect foreign keys: %w", err)
+	}
+
+	order, err := topoSortTables(names, edges, forced)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]tableSpec, 0, len(order))
+	for _, name := range order {
+		result = append(result, byName[name])
+	}
+	return result, nil
+}
+
+// topoSortTables orders names so that, for every edge child->parent present
+// in edges and with both ends in names, parent comes first. Tables in forced
+// are exempt from incoming-FK ordering, which also breaks any cycle that
+// only involves them. Split out of orderTables so the graph logic can be
+// tested without a database connection.
+func topoSortTables(names []string, edges map[string][]string, forced map[string]bool) ([]string, error) {
+	byName := make(map[string]bool, len(names))
+	for _, name := range names {
+		byName[name] = true
+	}
+
+	indegree := make(map[string]int, len(names))
+	children := make(map[string][]string)
+	for _, name := range names {
+		indegree[name] = 0
+	}
+	for child, parents := range edges {
+		if !byName[child] || forced[child] {
+			continue
+		}
+		for _, parent := range parents {
+			if !byName[parent] {
+				continue
+			}
+			children[parent] = append(children[parent], child)
+			indegree[child]++
+		}
+	}
+
+	queue := make([]string, 0, len(names))
+	for _, name := range names {
+		if indegree[name] == 0 {
+			queue = append(queue, name)
+		}
+	}
+
+	order := make([]string, 0, len(names))
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		order = append(order, name)
+		for _, child := range children[name] {
+			indegree[child]--
+			if indegree[child] == 0 {
+				queue = append(queue, child)
+			}
+		}
+	}
+
+	if len(order) != len(names) {
+		var stuck []string
+		for _, name := range names {
+			if indegree[name] > 0 {
+				stuck = append(stuck, name)
+			}
+		}
+		return nil, fmt.Errorf("circular foreign key dependency among tables: %s (add one to FORCE_TABLES to break the cycle)", strings.Join(stuck, ", "))
+	}
+
+	return order, nil
+}
+
+// fkEdges returns, for every foreign key constraint the destination declares
+// between two tables in names, an edge from the referencing (child) table to
+// the referenced (parent) table. Names are schema-qualified by joining
+// pg_class/pg_namespace directly rather than casting to regclass::text,
+// which Postgres renders without the schema prefix for anything on the
+// default search_path (i.e. every ordinary public.* table) and would never
+// match the always-qualified names orderTables builds from dest_table.
+func fkEdges(ctx context.Context, pool *pgxpool.Pool, names []string) (map[string][]string, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	q := `
+		SELECT cns.nspname || '.' || c.relname AS child, pns.nspname || '.' || p.relname AS parent
+		FROM pg_constraint con
+		JOIN pg_class c ON c.oid = con.conrelid
+		JOIN pg_namespace cns ON cns.oid = c.relnamespace
+		JOIN pg_class p ON p.oid = con.confrelid
+		JOIN pg_namespace pns ON pns.oid = p.relnamespace
+		WHERE con.contype = 'f'
+		  AND con.conrelid = ANY($1::regclass[])
+		  AND con.confrelid = ANY($1::regclass[])
+	`
+	start := time.Now()
+	rows, err := pool.Query(ctx, q, names)
+	if err != nil {
+		logQuery("fk_edges", "", start, 0, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	edges := make(map[string][]string)
+	for rows.Next() {
+		var child, parent string
+		if err := rows.Scan(&child, &parent); err != nil {
+			return nil, err
+		}
+		edges[child] = append(edges[child], parent)
+	}
+	err = rows.Err()
+	logQuery("fk_edges", "", start, int64(len(edges)), err)
+	return edges, err
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTopoSortTables(t *testing.T) {
+	cases := []struct {
+		name    string
+		names   []string
+		edges   map[string][]string
+		forced  map[string]bool
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "parent before child",
+			names: []string{"public.orders", "public.customers"},
+			edges: map[string][]string{"public.orders": {"public.customers"}},
+			want:  []string{"public.customers", "public.orders"},
+		},
+		{
+			name:  "edge outside manifest is ignored",
+			names: []string{"public.orders"},
+			edges: map[string][]string{"public.orders": {"public.customers"}},
+			want:  []string{"public.orders"},
+		},
+		{
+			name:    "cycle without a forced table errors",
+			names:   []string{"public.a", "public.b"},
+			edges:   map[string][]string{"public.a": {"public.b"}, "public.b": {"public.a"}},
+			wantErr: true,
+		},
+		{
+			name:   "forcing one side of a cycle breaks it",
+			names:  []string{"public.a", "public.b"},
+			edges:  map[string][]string{"public.a": {"public.b"}, "public.b": {"public.a"}},
+			forced: map[string]bool{"public.a": true},
+			want:   []string{"public.a", "public.b"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := topoSortTables(tc.names, tc.edges, tc.forced)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got order %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// rowSink accepts batches of rows in a fixed column order. It backs both the
+// Postgres CopyFrom path and the CSV file path.
+type rowSink interface {
+	write(ctx context.Context, columns []string, batch [][]any) error
+	close() error
+}
+
+// buildSink opens the configured sink for one worker. workerID is folded
+// into the output filename for a csv sink so concurrent workers never write
+// the same file.
+func buildSink(pool querier, cfg config, workerID int) (rowSink, error) {
+	if cfg.sinkType == "csv" {
+		path := cfg.sinkFile
+		if cfg.workers > 1 {
+			ext := filepath.Ext(path)
+			base := strings.TrimSuffix(path, ext)
+			path = fmt.Sprintf("%s.worker%d%s", base, workerID, ext)
+		}
+		return newCSVSink(path, cfg.csvOpts, cfg.sinkFileSizeBytes)
+	}
+	return newPgSink(pool, cfg.destTable), nil
+}
+
+// pgRowSink adapts insertBatch into a rowSink.
+type pgRowSink struct {
+	pool      querier
+	destTable pgx.Identifier
+}
+
+func newPgSink(pool querier, destTable pgx.Identifier) rowSink {
+	return &pgRowSink{pool: pool, destTable: destTable}
+}
+
+func (s *pgRowSink) write(ctx context.Context, columns []string, batch [][]any) error {
+	return insertBatch(ctx, s.pool, s.destTable, columns, batch)
+}
+
+func (s *pgRowSink) close() error { return nil }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// rowSource yields rows in a fixed column order, one batch-worth at a time
+// via repeated next calls. It backs both the Postgres SELECT path and the
+// CSV file path so the rest of the pipeline (batching, workers, checkpoints,
+// progress) doesn't need to know which one it's reading from.
+type rowSource interface {
+	columns() []string
+	next(ctx context.Context) ([]any, error) // returns io.EOF once exhausted
+	close()
+}
+
+// buildSource opens the configured source for one worker's shard. shardQuery
+// is only used when cfg.sourceType is "pg". readTimeout, if non-zero, bounds
+// the total lifetime of a pg source's query so a hung source doesn't block
+// the worker forever; a query still reading when it expires is cancelled too.
+func buildSource(ctx context.Context, pool querier, cfg config, shardQuery string, readTimeout time.Duration) (rowSource, error) {
+	if cfg.sourceType == "csv" {
+		return newCSVSource(cfg.sourceFile, cfg.csvOpts)
+	}
+	return newPgSource(ctx, pool, shardQuery, readTimeout)
+}
+
+// pgRowSource adapts pgx.Rows into a rowSource, and logs the SELECT's total
+// duration and row count once the cursor is closed.
+type pgRowSource struct {
+	rows     pgx.Rows
+	cols     []string
+	cancel   context.CancelFunc
+	start    time.Time
+	rowsSeen int64
+}
+
+func newPgSource(ctx context.Context, pool querier, query string, readTimeout time.Duration) (rowSource, error) {
+	queryCtx := ctx
+	var cancel context.CancelFunc
+	if readTimeout > 0 {
+		queryCtx, cancel = context.WithTimeout(ctx, readTimeout)
+	}
+
+	start := time.Now()
+	rows, cols, err := queryRows(queryCtx, pool, query)
+	if err != nil {
+		if cancel != nil {
+			cancel()
+		}
+		return nil, err
+	}
+	return &pgRowSource{rows: rows, cols: cols, cancel: cancel, start: start}, nil
+}
+
+func (s *pgRowSource) columns() []string { return s.cols }
+
+func (s *pgRowSource) next(_ context.Context) ([]any, error) {
+	if !s.rows.Next() {
+		if err := s.rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	s.rowsSeen++
+	return s.rows.Values()
+}
+
+func (s *pgRowSource) close() {
+	s.rows.Close()
+	logQuery("select", "", s.start, s.rowsSeen, s.rows.Err())
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const defaultVerifyBucketThreshold = int64(1_000_000)
+
+// verifyResult is the structured report produced by verifyTransfer. In
+// "full" mode it holds a full-table hash per side plus, once those disagree,
+// the columns whose hashes differ; in "bucketed" mode it holds no full-table
+// hash, only the buckets whose hashes differ.
+type verifyResult struct {
+	table            string
+	mode             string // "full" or "bucketed"
+	match            bool
+	sourceHash       string
+	destHash         string
+	columnMismatches []string
+	bucketMismatches []int64
+}
+
+// verifyTransfer re-reads cfg.selectQuery and cfg.destTable and compares a
+// deterministic hash of each side's rows, ordered by cfg.verifyPKColumn. It
+// is the only way this tool confirms CopyFrom reproduced the source exactly;
+// transferData on its own only checks that CopyFrom's row count matches.
+func verifyTransfer(ctx context.Context, pool querier, cfg config) (*verifyResult, error) {
+	if cfg.verifyPKColumn == "" {
+		return nil, fmt.Errorf("--verify-pk-column (or VERIFY_PK_COLUMN) is required when --verify is set")
+	}
+
+	columns, err := fetchColumns(ctx, pool, cfg.selectQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect select query columns: %w", err)
+	}
+
+	destQuery := fmt.Sprintf("SELECT * FROM %s", cfg.destTable.Sanitize())
+
+	var destCount int64
+	if err := pool.QueryRow(ctx, fmt.Sprintf("SELECT count(*) FROM (%s) dt_verify_count", destQuery)).Scan(&destCount); err != nil {
+		return nil, fmt.Errorf("failed to count destination rows: %w", err)
+	}
+
+	result := &verifyResult{table: strings.Join(cfg.destTable, ".")}
+
+	if destCount > defaultVerifyBucketThreshold {
+		return verifyBucketed(ctx, pool, cfg, destQuery, result)
+	}
+	return verifyFull(ctx, pool, cfg, columns, destQuery, result)
+}
+
+// verifyFull computes one md5 over all rows per side and, on mismatch, hashes
+// each column independently to identify which one diverges.
+func verifyFull(ctx context.Context, pool querier, cfg config, columns []string, destQuery string, result *verifyResult) (*verifyResult, error) {
+	result.mode = "full"
+
+	sourceHash, err := tableHash(ctx, pool, "source", cfg.selectQuery, cfg.verifyPKColumn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash source: %w", err)
+	}
+	destHash, err := tableHash(ctx, pool, "dest", destQuery, cfg.verifyPKColumn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash destination: %w", err)
+	}
+
+	result.sourceHash = sourceHash
+	result.destHash = destHash
+	result.match = sourceHash == destHash
+
+	if result.match {
+		return result, nil
+	}
+
+	for _, column := range columns {
+		if column == cfg.verifyPKColumn {
+			continue
+		}
+		srcColHash, err := columnHash(ctx, pool, "source", cfg.selectQuery, column, cfg.verifyPKColumn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash source column %s: %w", column, err)
+		}
+		destColHash, err := columnHash(ctx, pool, "dest", destQuery, column, cfg.verifyPKColumn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash destination column %s: %w", column, err)
+		}
+		if srcColHash != destColHash {
+			result.columnMismatches = append(result.columnMismatches, column)
+		}
+	}
+
+	return result, nil
+}
+
+// verifyBucketed falls back to per-bucket hashes for large tables so a
+// mismatch identifies a bucket of rows rather than requiring a full re-scan.
+func verifyBucketed(ctx context.Context, pool querier, cfg config, destQuery string, result *verifyResult) (*verifyResult, error) {
+	result.mode = "bucketed"
+
+	sourceBuckets, err := bucketHashes(ctx, pool, "source", cfg.selectQuery, cfg.verifyPKColumn, cfg.verifyBuckets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash source buckets: %w", err)
+	}
+	destBuckets, err := bucketHashes(ctx, pool, "dest", destQuery, cfg.verifyPKColumn, cfg.verifyBuckets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash destination buckets: %w", err)
+	}
+
+	result.match = true
+	for bucket, sourceHash := range sourceBuckets {
+		if destBuckets[bucket] != sourceHash {
+			result.match = false
+			result.bucketMismatches = append(result.bucketMismatches, bucket)
+		}
+	}
+	for bucket := range destBuckets {
+		if _, ok := sourceBuckets[bucket]; !ok {
+			result.match = false
+			result.bucketMismatches = append(result.bucketMismatches, bucket)
+		}
+	}
+
+	return result, nil
+}
+
+// tableHash hashes every row of query, ordered by pk, into a single md5.
+// label ("source" or "dest") identifies which side the query timing belongs
+// to, since query itself is just SQL text.
+func tableHash(ctx context.Context, pool querier, label, query, pk string) (string, error) {
+	q := fmt.Sprintf("SELECT coalesce(md5(string_agg(md5(row(t.*)::text), '' ORDER BY t.%s)), '') FROM (%s) t", pk, query)
+	var hash string
+	start := time.Now()
+	err := pool.QueryRow(ctx, q).Scan(&hash)
+	logQuery("verify_table_hash:"+label, "", start, 1, err)
+	if err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// columnHash hashes a single column across all rows of query, ordered by pk.
+func columnHash(ctx context.Context, pool querier, label, query, column, pk string) (string, error) {
+	q := fmt.Sprintf(
+		"SELECT coalesce(md5(string_agg(coalesce(t.%s::text,''), '' ORDER BY t.%s)), '') FROM (%s) t",
+		column, pk, query,
+	)
+	var hash string
+	start := time.Now()
+	err := pool.QueryRow(ctx, q).Scan(&hash)
+	logQuery("verify_column_hash:"+label, "", start, 1, err)
+	if err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// bucketHashes groups rows of query into numBuckets buckets by hashtext(pk)
+// and returns one md5 per populated bucket.
+func bucketHashes(ctx context.Context, pool querier, label, query, pk string, numBuckets int) (map[int64]string, error) {
+	q := fmt.Sprintf(
+		`SELECT abs(hashtext(t.%s::text)) %% %d AS bucket, md5(string_agg(md5(row(t.*)::text), '' ORDER BY t.%s))
+		 FROM (%s) t
+		 GROUP BY bucket
+		 ORDER BY bucket`,
+		pk, numBuckets, pk, query,
+	)
+	start := time.Now()
+	rows, err := pool.Query(ctx, q)
+	if err != nil {
+		logQuery("verify_bucket_hash:"+label, "", start, 0, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := make(map[int64]string)
+	for rows.Next() {
+		var bucket int64
+		var hash string
+		if err := rows.Scan(&bucket, &hash); err != nil {
+			return nil, err
+		}
+		buckets[bucket] = hash
+	}
+	err = rows.Err()
+	logQuery("verify_bucket_hash:"+label, "", start, int64(len(buckets)), err)
+	return buckets, err
+}
+
+// fetchColumns returns the column names a query would produce, without
+// running it to completion.
+func fetchColumns(ctx context.Context, pool querier, query string) ([]string, error) {
+	start := time.Now()
+	rows, err := pool.Query(ctx, fmt.Sprintf("SELECT * FROM (%s) dt_columns LIMIT 0", query))
+	logQuery("verify_columns", "", start, 0, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make([]string, 0, len(rows.FieldDescriptions()))
+	for _, fd := range rows.FieldDescriptions() {
+		columns = append(columns, string(fd.Name))
+	}
+	return columns, nil
+}
+
+// logReport prints the verification report and returns a non-nil error when
+// the source and destination do not match, so the caller can exit non-zero.
+func logReport(result *verifyResult) error {
+	slog.Info("verification", "table", result.table, "mode", result.mode, "match", result.match)
+
+	if result.match {
+		return nil
+	}
+
+	switch result.mode {
+	case "full":
+		slog.Error("verification mismatch",
+			"table", result.table, "source_hash", result.sourceHash, "dest_hash", result.destHash,
+			"mismatched_columns", strings.Join(result.columnMismatches, ", "),
+		)
+	case "bucketed":
+		slog.Error("verification mismatch", "table", result.table, "mismatched_buckets", result.bucketMismatches)
+	}
+
+	return fmt.Errorf("verification failed for %s: source and destination diverge", result.table)
+}